@@ -0,0 +1,85 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package register is a small helper library for plugins (device plugins,
+// CSI drivers, ...) that want to register with the kubelet's
+// pluginwatcher: it lets a plugin probe whether the kubelet supports the
+// plugin-watcher registration protocol at all, and publish its socket
+// without the watcher ever observing a half-initialized file.
+package register
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// ProbeWatcherRoot reports whether root -- the kubelet plugin socket
+// directory a plugin would otherwise use the legacy Register RPC against
+// -- exists. The kubelet's pluginwatcher.Watcher creates its root
+// directory before it starts watching and never removes it while running,
+// so this is the only thing an external plugin process can observe to
+// decide whether to drop a socket into root or fall back to the legacy
+// Register RPC against the kubelet gRPC endpoint.
+func ProbeWatcherRoot(root string) bool {
+	info, err := os.Stat(root)
+	return err == nil && info.IsDir()
+}
+
+// PublishSocket starts listening for gRPC connections at
+// filepath.Join(dir, name), guaranteeing that the socket never appears at
+// that path until it is already bound and accepting connections. It does
+// this by listening on a dot-prefixed temporary path inside dir itself and
+// renaming the bound socket into place, rather than binding in some other
+// directory: the final rename is an os.Rename, which fails with EXDEV if
+// the temporary path and dir aren't on the same filesystem, and nothing
+// guarantees that of an arbitrary directory (the OS temporary directory in
+// particular is routinely its own tmpfs mount, separate from wherever the
+// kubelet's plugin directory lives). Binding inside dir instead guarantees
+// the rename is same-filesystem. The kubelet's plugin-watcher ignores any
+// dot-prefixed name it observes (see Watcher's isIgnoredPath), so the
+// half-initialized socket is never picked up and dialed before the rename
+// completes. The caller is responsible for closing the returned listener.
+func PublishSocket(dir, name string) (net.Listener, string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, "", fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+
+	tmpFile, err := ioutil.TempFile(dir, "."+name+".")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to reserve a temporary socket path in %s: %v", dir, err)
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	if err := os.Remove(tmpPath); err != nil {
+		return nil, "", fmt.Errorf("failed to clear placeholder %s: %v", tmpPath, err)
+	}
+
+	lis, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to listen on %s: %v", tmpPath, err)
+	}
+
+	socketPath := filepath.Join(dir, name)
+	if err := os.Rename(tmpPath, socketPath); err != nil {
+		lis.Close()
+		return nil, "", fmt.Errorf("failed to publish socket at %s: %v", socketPath, err)
+	}
+
+	return lis, socketPath, nil
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pluginwatcher
+
+import "sync"
+
+// registeredPlugin is what ActualStateOfWorld remembers about a plugin
+// whose handler has acknowledged its registration, so the reconciler can
+// later tell the right handler to deregister it.
+type registeredPlugin struct {
+	pluginName string
+	pluginType string
+	// generation is the DesiredStateOfWorld generation of the socket this
+	// plugin was registered from. The reconciler compares it against the
+	// socket's current generation to notice it's since been replaced by a
+	// new socket instance at the same path.
+	generation int64
+}
+
+// ActualStateOfWorld tracks which plugin sockets the registered handlers
+// have acked, so the reconciler can tell which desired plugins are new and
+// which registered plugins have gone away.
+type ActualStateOfWorld interface {
+	// AddPlugin records that socketPath was successfully registered as
+	// pluginName of pluginType, at the given DesiredStateOfWorld
+	// generation.
+	AddPlugin(socketPath, pluginName, pluginType string, generation int64)
+	// RemovePlugin records that socketPath is no longer registered.
+	RemovePlugin(socketPath string)
+	// GetRegisteredPlugins returns every currently registered plugin,
+	// keyed by socket path.
+	GetRegisteredPlugins() map[string]registeredPlugin
+}
+
+type actualStateOfWorld struct {
+	sync.RWMutex
+	plugins map[string]registeredPlugin
+}
+
+// NewActualStateOfWorld returns an empty ActualStateOfWorld.
+func NewActualStateOfWorld() ActualStateOfWorld {
+	return &actualStateOfWorld{plugins: make(map[string]registeredPlugin)}
+}
+
+func (asw *actualStateOfWorld) AddPlugin(socketPath, pluginName, pluginType string, generation int64) {
+	asw.Lock()
+	defer asw.Unlock()
+	asw.plugins[socketPath] = registeredPlugin{pluginName: pluginName, pluginType: pluginType, generation: generation}
+}
+
+func (asw *actualStateOfWorld) RemovePlugin(socketPath string) {
+	asw.Lock()
+	defer asw.Unlock()
+	delete(asw.plugins, socketPath)
+}
+
+func (asw *actualStateOfWorld) GetRegisteredPlugins() map[string]registeredPlugin {
+	asw.RLock()
+	defer asw.RUnlock()
+	plugins := make(map[string]registeredPlugin, len(asw.plugins))
+	for k, v := range asw.plugins {
+		plugins[k] = v
+	}
+	return plugins
+}
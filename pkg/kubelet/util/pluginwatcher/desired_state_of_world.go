@@ -0,0 +1,73 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pluginwatcher
+
+import "sync"
+
+// DesiredStateOfWorld tracks every plugin socket currently believed to
+// exist on disk, as discovered by fsnotify events and the initial
+// directory traversal done on Watcher.Start. It says nothing about whether
+// a handler has actually acknowledged the plugin yet; that's what
+// ActualStateOfWorld is for.
+type DesiredStateOfWorld interface {
+	// AddOrUpdatePlugin records that socketPath exists, under a new
+	// generation: even if socketPath was already present, the Reconciler
+	// treats this as a distinct socket instance from whatever it last
+	// registered there, e.g. if a fast plugin restart's Remove and Create
+	// coalesced into a single buffered event and the explicit
+	// RemovePlugin below was never observed.
+	AddOrUpdatePlugin(socketPath string)
+	// RemovePlugin records that socketPath no longer exists.
+	RemovePlugin(socketPath string)
+	// GetPluginsToRegister returns the current generation of every socket
+	// path currently known to exist.
+	GetPluginsToRegister() map[string]int64
+}
+
+type desiredStateOfWorld struct {
+	sync.RWMutex
+	socketPaths map[string]int64
+	nextGen     int64
+}
+
+// NewDesiredStateOfWorld returns an empty DesiredStateOfWorld.
+func NewDesiredStateOfWorld() DesiredStateOfWorld {
+	return &desiredStateOfWorld{socketPaths: make(map[string]int64)}
+}
+
+func (dsw *desiredStateOfWorld) AddOrUpdatePlugin(socketPath string) {
+	dsw.Lock()
+	defer dsw.Unlock()
+	dsw.nextGen++
+	dsw.socketPaths[socketPath] = dsw.nextGen
+}
+
+func (dsw *desiredStateOfWorld) RemovePlugin(socketPath string) {
+	dsw.Lock()
+	defer dsw.Unlock()
+	delete(dsw.socketPaths, socketPath)
+}
+
+func (dsw *desiredStateOfWorld) GetPluginsToRegister() map[string]int64 {
+	dsw.RLock()
+	defer dsw.RUnlock()
+	socketPaths := make(map[string]int64, len(dsw.socketPaths))
+	for k, v := range dsw.socketPaths {
+		socketPaths[k] = v
+	}
+	return socketPaths
+}
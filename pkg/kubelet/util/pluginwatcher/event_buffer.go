@@ -0,0 +1,88 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pluginwatcher
+
+import (
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+
+	"k8s.io/klog"
+)
+
+// pluginEventBuffer is a bounded, path-keyed buffer of undrained fsnotify
+// events. Put is cheap (a map write under a mutex) so the fsnotify read
+// loop can keep draining fsWatcher.Events even while a socket path is
+// churning through rapid create/remove events; only the final operation
+// for each path is kept, so Drain never yields more than one event per
+// path for a given burst.
+type pluginEventBuffer struct {
+	mutex    sync.Mutex
+	order    []string
+	state    map[string]fsnotify.Op
+	capacity int
+}
+
+// newPluginEventBuffer returns an empty pluginEventBuffer that holds
+// undrained events for at most capacity distinct paths.
+func newPluginEventBuffer(capacity int) *pluginEventBuffer {
+	return &pluginEventBuffer{
+		state:    make(map[string]fsnotify.Op),
+		capacity: capacity,
+	}
+}
+
+// Put records that path's most recently observed fsnotify operation is op,
+// overwriting any not-yet-drained operation recorded for the same path. If
+// the buffer is already tracking capacity distinct paths and path is new,
+// the oldest undrained path is evicted to make room.
+//
+// Coalescing a Remove followed by a Create down to just a Create is safe:
+// DesiredStateOfWorld.AddOrUpdatePlugin assigns a fresh generation on every
+// call, so the Reconciler still notices the socket was replaced even though
+// the intermediate Remove was never individually applied.
+func (b *pluginEventBuffer) Put(path string, op fsnotify.Op) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.state[path]; !ok {
+		if len(b.order) >= b.capacity {
+			oldest := b.order[0]
+			b.order = b.order[1:]
+			delete(b.state, oldest)
+			klog.V(4).Infof("plugin event buffer full, dropping stale event for %s", oldest)
+		}
+		b.order = append(b.order, path)
+	}
+	b.state[path] = op
+}
+
+// Drain removes and returns the oldest undrained (path, op) pair. The
+// second return value is false if the buffer is empty.
+func (b *pluginEventBuffer) Drain() (string, fsnotify.Op, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.order) == 0 {
+		return "", 0, false
+	}
+	path := b.order[0]
+	b.order = b.order[1:]
+	op := b.state[path]
+	delete(b.state, path)
+	return path, op, true
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pluginwatcher
+
+import (
+	"fmt"
+	"sync"
+)
+
+// exampleHandler is a fake PluginHandler used by tests. It acks every plugin
+// whose name isn't currently registered and rejects a plugin with an empty
+// endpoint or a name that is currently registered, to exercise both the
+// success and failure paths. A name is accepted again once DeRegisterPlugin
+// has been called for it.
+type exampleHandler struct {
+	mutex               sync.Mutex
+	registeredPlugins   map[string]bool
+	deregisteredPlugins map[string]bool
+	registeredVersions  map[string]string
+}
+
+// NewExampleHandler returns an exampleHandler ready to be passed to
+// Watcher.AddHandler.
+func NewExampleHandler() *exampleHandler {
+	return &exampleHandler{
+		registeredPlugins:   make(map[string]bool),
+		deregisteredPlugins: make(map[string]bool),
+		registeredVersions:  make(map[string]string),
+	}
+}
+
+// RegisterPlugin implements PluginHandler.
+func (h *exampleHandler) RegisterPlugin(pluginName, endpoint, version, socketPath string) error {
+	if endpoint == "" {
+		return fmt.Errorf("empty endpoint for plugin %s", pluginName)
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.registeredPlugins[pluginName] {
+		return fmt.Errorf("plugin %s already registered", pluginName)
+	}
+	h.registeredPlugins[pluginName] = true
+	h.registeredVersions[pluginName] = version
+	delete(h.deregisteredPlugins, pluginName)
+	return nil
+}
+
+// DeRegisterPlugin implements PluginHandler.
+func (h *exampleHandler) DeRegisterPlugin(pluginName string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.registeredPlugins, pluginName)
+	h.deregisteredPlugins[pluginName] = true
+}
+
+// isDeregistered reports whether pluginName has been deregistered since the
+// handler was created or last Cleanup, and not registered again since.
+func (h *exampleHandler) isDeregistered(pluginName string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.deregisteredPlugins[pluginName]
+}
+
+// isRegistered reports whether pluginName is currently registered.
+func (h *exampleHandler) isRegistered(pluginName string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.registeredPlugins[pluginName]
+}
+
+// registeredVersion returns the registration protocol version pluginName
+// last registered with, or "" if it has never registered.
+func (h *exampleHandler) registeredVersion(pluginName string) string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.registeredVersions[pluginName]
+}
+
+// registeredCount returns how many plugins are currently recorded as
+// registered. It exists so tests can assert against the handler's view of
+// the world without reaching into registeredPlugins directly, which would
+// race with RegisterPlugin/DeRegisterPlugin running on the reconciler's
+// goroutine.
+func (h *exampleHandler) registeredCount() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.registeredPlugins)
+}
+
+// Cleanup resets the handler's view of which plugins have registered, e.g.
+// to simulate the watcher restarting with a fresh handler.
+func (h *exampleHandler) Cleanup() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.registeredPlugins = make(map[string]bool)
+	h.deregisteredPlugins = make(map[string]bool)
+	h.registeredVersions = make(map[string]string)
+	return nil
+}
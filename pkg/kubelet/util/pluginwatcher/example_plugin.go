@@ -0,0 +1,220 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pluginwatcher
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+
+	"k8s.io/kubernetes/pkg/kubelet/apis/pluginregistration/register"
+	registerapi "k8s.io/kubernetes/pkg/kubelet/apis/pluginregistration/v1"
+	registerapiv1alpha1 "k8s.io/kubernetes/pkg/kubelet/apis/pluginregistration/v1alpha1"
+)
+
+// examplePlugin is a fake plugin used by tests to exercise Watcher without
+// standing up a real device plugin or CSI driver.
+type examplePlugin struct {
+	name               string
+	pluginType         string
+	endpoint           string
+	advertisedVersions []string
+	declaredVersions   []string
+
+	signerName string
+	signingKey *rsa.PrivateKey
+
+	grpcServer         *grpc.Server
+	wg                 sync.WaitGroup
+	registrationStatus chan registerapiv1alpha1.RegistrationStatus
+}
+
+// NewTestExamplePlugin returns a plugin that will serve GetInfo/NotifyRegistrationStatus
+// for every version in advertisedVersions. If advertisedVersions is empty, the
+// plugin advertises every version the kubelet understands.
+func NewTestExamplePlugin(name string, pluginType string, endpoint string, advertisedVersions ...string) *examplePlugin {
+	if len(advertisedVersions) == 0 {
+		advertisedVersions = []string{registerapi.APIVersion, registerapiv1alpha1.APIVersion}
+	}
+	return &examplePlugin{
+		name:               name,
+		pluginType:         pluginType,
+		endpoint:           endpoint,
+		advertisedVersions: advertisedVersions,
+		registrationStatus: make(chan registerapiv1alpha1.RegistrationStatus),
+	}
+}
+
+// WithSignedInfo configures p to sign its PluginInfo manifest with key
+// under signerName before advertising it, so a Watcher with a TrustPolicy
+// trusting signerName's public key will accept its registration. It
+// returns p, for chaining off of NewTestExamplePlugin.
+func (p *examplePlugin) WithSignedInfo(signerName string, key *rsa.PrivateKey) *examplePlugin {
+	p.signerName = signerName
+	p.signingKey = key
+	return p
+}
+
+// WithDeclaredVersions overrides the SupportedVersions advertised in the
+// plugin's PluginInfo to declared, independent of advertisedVersions (which
+// controls which gRPC services Serve actually registers), so tests can
+// exercise a plugin whose wire services and advertised capabilities
+// disagree. It returns p, for chaining off of NewTestExamplePlugin.
+func (p *examplePlugin) WithDeclaredVersions(declared ...string) *examplePlugin {
+	p.declaredVersions = declared
+	return p
+}
+
+func (p *examplePlugin) supports(version string) bool {
+	for _, v := range p.advertisedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// Serve starts a gRPC server for the plugin at socketPath, registering the
+// Registration service for every version the plugin advertises.
+func (p *examplePlugin) Serve(socketPath string) error {
+	lis, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	p.serve(lis)
+	return nil
+}
+
+// PublishAndServe behaves like Serve, but publishes its socket at
+// filepath.Join(dir, p.name+".sock") via register.PublishSocket instead of
+// listening on a caller-chosen path directly, so the watcher never sees
+// the socket before the plugin is ready to accept connections on it. It
+// returns the socket path it ended up publishing at.
+func (p *examplePlugin) PublishAndServe(dir string) (string, error) {
+	lis, socketPath, err := register.PublishSocket(dir, p.name+".sock")
+	if err != nil {
+		return "", err
+	}
+	p.serve(lis)
+	return socketPath, nil
+}
+
+func (p *examplePlugin) serve(lis net.Listener) {
+	p.grpcServer = grpc.NewServer()
+	if p.supports(registerapi.APIVersion) {
+		registerapi.RegisterRegistrationServer(p.grpcServer, p)
+	}
+	if p.supports(registerapiv1alpha1.APIVersion) {
+		registerapiv1alpha1.RegisterRegistrationServer(p.grpcServer, p.asV1alpha1())
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.grpcServer.Serve(lis)
+	}()
+}
+
+// Stop stops the plugin's gRPC server, which also removes a socket created
+// by Serve. A socket published by PublishAndServe is left in place: its
+// listener was bound at the temporary path, and that is the path closing it
+// unlinks.
+func (p *examplePlugin) Stop() error {
+	if p.grpcServer == nil {
+		return nil
+	}
+	p.grpcServer.Stop()
+	p.wg.Wait()
+	return nil
+}
+
+func (p *examplePlugin) pluginInfo() *registerapi.PluginInfo {
+	supportedVersions := p.advertisedVersions
+	if p.declaredVersions != nil {
+		supportedVersions = p.declaredVersions
+	}
+	info := &registerapi.PluginInfo{
+		Type:              p.pluginType,
+		Name:              p.name,
+		Endpoint:          p.endpoint,
+		SupportedVersions: supportedVersions,
+	}
+	if p.signingKey != nil {
+		digest := sha256.Sum256(canonicalManifest(info))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, p.signingKey, crypto.SHA256, digest[:])
+		if err != nil {
+			// The only reasons SignPKCS1v15 fails here are a malformed key
+			// or too-small modulus, both programmer errors in test setup.
+			panic(err)
+		}
+		info.Digest = hex.EncodeToString(digest[:])
+		info.Signature = signature
+		info.Signer = p.signerName
+	}
+	return info
+}
+
+// GetInfo implements registerapi.RegistrationServer (v1).
+func (p *examplePlugin) GetInfo(ctx context.Context, req *registerapi.InfoRequest) (*registerapi.PluginInfo, error) {
+	return p.pluginInfo(), nil
+}
+
+// NotifyRegistrationStatus implements registerapi.RegistrationServer (v1).
+func (p *examplePlugin) NotifyRegistrationStatus(ctx context.Context, status *registerapi.RegistrationStatus) (*registerapi.RegistrationStatusResponse, error) {
+	p.registrationStatus <- registerapiv1alpha1.RegistrationStatus{
+		PluginRegistered: status.PluginRegistered,
+		Error:            status.Error,
+	}
+	return &registerapi.RegistrationStatusResponse{}, nil
+}
+
+// v1alpha1Shim backs the v1alpha1 Registration service. examplePlugin
+// itself implements the v1 service directly; v1alpha1's GetInfo and
+// NotifyRegistrationStatus take different concrete types for the same
+// method names, so they're implemented on this wrapper instead.
+type v1alpha1Shim struct {
+	*examplePlugin
+}
+
+func (p *examplePlugin) asV1alpha1() registerapiv1alpha1.RegistrationServer {
+	return v1alpha1Shim{p}
+}
+
+func (s v1alpha1Shim) GetInfo(ctx context.Context, req *registerapiv1alpha1.InfoRequest) (*registerapiv1alpha1.PluginInfo, error) {
+	info := s.pluginInfo()
+	return &registerapiv1alpha1.PluginInfo{
+		Type:              info.Type,
+		Name:              info.Name,
+		Endpoint:          info.Endpoint,
+		SupportedVersions: info.SupportedVersions,
+		Digest:            info.Digest,
+		Signature:         info.Signature,
+		Signer:            info.Signer,
+	}, nil
+}
+
+func (s v1alpha1Shim) NotifyRegistrationStatus(ctx context.Context, status *registerapiv1alpha1.RegistrationStatus) (*registerapiv1alpha1.RegistrationStatusResponse, error) {
+	s.registrationStatus <- *status
+	return &registerapiv1alpha1.RegistrationStatusResponse{}, nil
+}
@@ -0,0 +1,612 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pluginwatcher watches a directory for plugin sockets (device
+// plugins, CSI drivers, ...) being created and removed, and notifies
+// registered handlers when a plugin registers itself with the kubelet.
+package pluginwatcher
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	utilfs "k8s.io/kubernetes/pkg/util/filesystem"
+
+	registerapi "k8s.io/kubernetes/pkg/kubelet/apis/pluginregistration/v1"
+	registerapiv1alpha1 "k8s.io/kubernetes/pkg/kubelet/apis/pluginregistration/v1alpha1"
+)
+
+// supportedVersions are the registration protocol versions the kubelet
+// understands, in order of preference (most preferred first).
+var supportedVersions = []string{registerapi.APIVersion, registerapiv1alpha1.APIVersion}
+
+// dialTimeout is how long we wait to dial a plugin's unix socket before
+// giving up on it.
+const dialTimeout = 10 * time.Second
+
+// errChannelCapacity buffers the channel Start returns so that a handful of
+// errors arriving in a burst (e.g. one per plugin type failing trust
+// verification at once) don't have to wait for the caller to drain them.
+const errChannelCapacity = 32
+
+// PluginHandler is implemented by subsystems (device manager, CSI plugin
+// manager, ...) that want to react to plugins registering and
+// deregistering with the kubelet.
+type PluginHandler interface {
+	// RegisterPlugin is called when a plugin registers (or re-registers)
+	// at socketPath, having successfully negotiated version with the
+	// watcher. pluginName and endpoint come from the plugin's PluginInfo.
+	// A non-nil error is reported back to the plugin as a failed
+	// registration.
+	RegisterPlugin(pluginName, endpoint, version, socketPath string) error
+	// DeRegisterPlugin is called when a previously registered plugin's
+	// socket has disappeared, or has been replaced by a new socket at the
+	// same path.
+	DeRegisterPlugin(pluginName string)
+}
+
+// WatcherOptions tunes the fsnotify event pipeline. Events go into a
+// bounded, path-keyed buffer that coalesces rapid create/remove churn for
+// the same socket into a single final-state event before a pool of
+// workers drains it into the DesiredStateOfWorld; this keeps the fsnotify
+// read loop from ever blocking on slow downstream processing.
+type WatcherOptions struct {
+	// EventBufferSize bounds how many distinct socket paths may have an
+	// undrained event at once. Once full, the oldest undrained path is
+	// evicted to make room, on the assumption that a path churning fast
+	// enough to fill the buffer will generate another event soon anyway.
+	EventBufferSize int
+	// WorkerCount is how many goroutines concurrently drain the event
+	// buffer into the DesiredStateOfWorld.
+	WorkerCount int
+	// CoalesceInterval is how often each worker polls the event buffer
+	// for new work.
+	CoalesceInterval time.Duration
+}
+
+// DefaultWatcherOptions returns the WatcherOptions NewWatcher uses when
+// none are supplied.
+func DefaultWatcherOptions() WatcherOptions {
+	return WatcherOptions{
+		EventBufferSize:  1024,
+		WorkerCount:      4,
+		CoalesceInterval: 10 * time.Millisecond,
+	}
+}
+
+// Watcher watches a directory hierarchy of plugin sockets and dispatches to
+// handlers registered per plugin type (e.g. "DevicePlugin", "CSIPlugin").
+// It discovers sockets via fsnotify into a bounded event buffer that
+// coalesces per-path churn, drains that into a DesiredStateOfWorld, and a
+// Reconciler periodically registers and deregisters plugins by diffing that
+// against an ActualStateOfWorld of handler-acked plugins.
+type Watcher struct {
+	path      string
+	fs        utilfs.Filesystem
+	fsWatcher *fsnotify.Watcher
+	options   WatcherOptions
+
+	mutex         sync.Mutex
+	handlers      map[string]PluginHandler
+	trustPolicy   *TrustPolicy
+	probeFailures int
+
+	dsw         DesiredStateOfWorld
+	asw         ActualStateOfWorld
+	reconciler  *Reconciler
+	eventBuffer *pluginEventBuffer
+	workersWG   sync.WaitGroup
+
+	errCh   chan error
+	stopCh  chan struct{}
+	stopped chan struct{}
+}
+
+// NewWatcher returns a Watcher that will watch sockDir (and every directory
+// below it, at any depth) once Start is called. options defaults to
+// DefaultWatcherOptions if not supplied.
+func NewWatcher(sockDir string, options ...WatcherOptions) *Watcher {
+	opts := DefaultWatcherOptions()
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	return &Watcher{
+		path:     sockDir,
+		fs:       &utilfs.DefaultFs{},
+		handlers: make(map[string]PluginHandler),
+		options:  opts,
+	}
+}
+
+// AddHandler registers the handler to be invoked whenever a plugin of the
+// given type registers or deregisters with the watcher. It must be called
+// before Start.
+func (w *Watcher) AddHandler(pluginType string, handler PluginHandler) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.handlers[pluginType] = handler
+}
+
+func (w *Watcher) getHandler(pluginType string) (PluginHandler, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	h, ok := w.handlers[pluginType]
+	return h, ok
+}
+
+// SetTrustPolicy installs policy, so every plugin registering from now on
+// must pass verification against it before any handler is invoked; a
+// plugin that fails verification is rejected with ErrUntrustedPlugin
+// instead. Pass a zero-value TrustPolicy to require every plugin be
+// signed by no one (i.e. reject everything); there is no way to disable
+// an already-set policy short of constructing a new Watcher.
+func (w *Watcher) SetTrustPolicy(policy TrustPolicy) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.trustPolicy = &policy
+}
+
+func (w *Watcher) getTrustPolicy() *TrustPolicy {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.trustPolicy
+}
+
+func (w *Watcher) recordProbeFailure() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.probeFailures++
+}
+
+// ProbeFailureCount reports how many times the Reconciler has dialed a
+// socket or called GetInfo on it and failed, e.g. "connection refused"
+// against a socket a plugin hasn't finished publishing yet. It's meant for
+// tests asserting that a publish path (like register.PublishSocket) never
+// lets the watcher observe a half-initialized socket.
+func (w *Watcher) ProbeFailureCount() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.probeFailures
+}
+
+// Start watches w.path, traverses it once for sockets that already exist
+// (e.g. left over from a kubelet restart), and then streams fsnotify
+// events into a bounded, coalescing event buffer until Stop is called. A
+// pool of workers drains that buffer into a DesiredStateOfWorld, and a
+// Reconciler runs alongside, periodically registering sockets that are
+// desired but not yet registered and deregistering ones that are
+// registered but no longer desired. The returned channel carries
+// non-recoverable errors from the fsnotify event loop and, from the
+// Reconciler, every *ErrUntrustedPlugin rejection; a nil value is never
+// sent on it.
+func (w *Watcher) Start() (chan error, error) {
+	klog.V(2).Infof("Plugin Watcher Start at %s", w.path)
+	w.stopCh = make(chan struct{})
+	w.stopped = make(chan struct{})
+	w.dsw = NewDesiredStateOfWorld()
+	w.asw = NewActualStateOfWorld()
+	w.reconciler = NewReconciler(w.dsw, w.asw, w.registerPluginAt, w.deregisterPlugin)
+	w.eventBuffer = newPluginEventBuffer(w.options.EventBufferSize)
+
+	if err := w.fs.MkdirAll(w.path, 0755); err != nil {
+		return nil, fmt.Errorf("error creating socket directory %s: %v", w.path, err)
+	}
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("error creating fsnotify watcher: %v", err)
+	}
+	w.fsWatcher = fsWatcher
+
+	errCh := make(chan error, errChannelCapacity)
+	w.errCh = errCh
+
+	if err := w.traversePluginDir(w.path); err != nil {
+		fsWatcher.Close()
+		return nil, fmt.Errorf("failed to traverse plugin socket path %s: %v", w.path, err)
+	}
+
+	go w.reconciler.Run(w.stopCh)
+
+	w.workersWG.Add(w.options.WorkerCount)
+	for i := 0; i < w.options.WorkerCount; i++ {
+		go w.runEventWorker()
+	}
+
+	go func() {
+		defer close(w.stopped)
+		for {
+			select {
+			case event := <-fsWatcher.Events:
+				w.handleEvent(event)
+			case err := <-fsWatcher.Errors:
+				if err != nil {
+					w.sendError(err)
+				}
+			case <-w.stopCh:
+				return
+			}
+		}
+	}()
+
+	return errCh, nil
+}
+
+// Stop stops the fsnotify event loop and the event buffer workers. It does
+// not remove the watched directory; callers wanting a clean slate should
+// also call Cleanup.
+func (w *Watcher) Stop() error {
+	close(w.stopCh)
+
+	select {
+	case <-w.stopped:
+	case <-time.After(10 * time.Second):
+		return fmt.Errorf("timed out waiting for plugin watcher to stop")
+	}
+	w.workersWG.Wait()
+
+	if w.fsWatcher != nil {
+		return w.fsWatcher.Close()
+	}
+	return nil
+}
+
+// runEventWorker polls the event buffer every CoalesceInterval, draining
+// and applying every event currently buffered, until stopCh is closed.
+func (w *Watcher) runEventWorker() {
+	defer w.workersWG.Done()
+	ticker := time.NewTicker(w.options.CoalesceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for {
+				path, op, ok := w.eventBuffer.Drain()
+				if !ok {
+					break
+				}
+				w.applyEvent(path, op)
+			}
+		case <-w.stopCh:
+			return
+		}
+	}
+}
+
+// applyEvent updates the DesiredStateOfWorld for the final fsnotify
+// operation a socket path settled on.
+func (w *Watcher) applyEvent(path string, op fsnotify.Op) {
+	if op&fsnotify.Remove == fsnotify.Remove {
+		klog.V(4).Infof("socket %s removed, dropping it from the desired state", path)
+		w.dsw.RemovePlugin(path)
+		return
+	}
+
+	isSocket, err := w.socketExists(path)
+	if err != nil {
+		klog.Errorf("failed to check if %s is a socket: %v", path, err)
+		return
+	}
+	if !isSocket {
+		return
+	}
+	w.dsw.AddOrUpdatePlugin(path)
+}
+
+// Cleanup removes the socket directory the watcher was watching.
+func (w *Watcher) Cleanup() error {
+	return w.fs.RemoveAll(w.path)
+}
+
+// ProbeSocketPath reports whether this Watcher's root directory currently
+// exists and is being watched, i.e. whether a plugin creating its socket
+// somewhere under it right now would be picked up. It's meant for other
+// in-process kubelet code (tests, health checks) that already holds a
+// reference to the Watcher; an external plugin process can't call this
+// directly and should use the filesystem-based
+// pluginregistration/register.ProbeWatcherRoot helper instead, which makes
+// the same check without needing a Watcher reference.
+func (w *Watcher) ProbeSocketPath() bool {
+	if w.fsWatcher == nil {
+		return false
+	}
+	select {
+	case <-w.stopped:
+		return false
+	default:
+	}
+	info, err := w.fs.Stat(w.path)
+	return err == nil && info.IsDir()
+}
+
+// isIgnoredPath reports whether path has a dot-prefixed base name, the
+// convention a plugin publishing its socket with
+// pluginregistration/register.PublishSocket relies on to keep its
+// half-initialized temporary socket invisible to the watcher while it's
+// bound in dir but not yet renamed into its final, non-dot-prefixed name.
+func isIgnoredPath(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".")
+}
+
+// traversePluginDir walks dir, adding every directory to the fsnotify
+// watcher and recording every socket found in the desired state of world,
+// so a kubelet restart re-discovers plugins that registered before it came
+// up. Start begins with an empty ActualStateOfWorld, so the reconciler
+// registers each of these sockets again.
+func (w *Watcher) traversePluginDir(dir string) error {
+	if err := w.fsWatcher.Add(dir); err != nil {
+		return fmt.Errorf("failed to watch %s: %v", dir, err)
+	}
+
+	files, err := w.fs.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read dir %s: %v", dir, err)
+	}
+
+	for _, file := range files {
+		if isIgnoredPath(file.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		if file.IsDir() {
+			if err := w.traversePluginDir(path); err != nil {
+				return err
+			}
+			continue
+		}
+		if isSocket, err := w.socketExists(path); err != nil {
+			return err
+		} else if isSocket {
+			w.dsw.AddOrUpdatePlugin(path)
+		}
+	}
+	return nil
+}
+
+// handleEvent is the only thing run on the fsnotify read loop's goroutine,
+// so it does the bare minimum: a new directory is traversed inline (it
+// needs to be added to the fsWatcher before more events can be missed),
+// but a socket create/remove is just dropped into the event buffer for a
+// worker to pick up, so a flood of socket churn never backs up fsnotify's
+// own internal event queue.
+func (w *Watcher) handleEvent(event fsnotify.Event) {
+	klog.V(6).Infof("Received event: %v", event)
+
+	if isIgnoredPath(event.Name) {
+		return
+	}
+
+	if event.Op&fsnotify.Create == fsnotify.Create {
+		info, err := w.fs.Stat(event.Name)
+		if err != nil {
+			// The path may already be gone if a create was immediately
+			// followed by a remove; nothing to traverse or buffer.
+			return
+		}
+		if info.IsDir() {
+			if err := w.traversePluginDir(event.Name); err != nil {
+				w.sendError(fmt.Errorf("failed to traverse new directory %s: %v", event.Name, err))
+			}
+			return
+		}
+		w.eventBuffer.Put(event.Name, fsnotify.Create)
+	} else if event.Op&fsnotify.Remove == fsnotify.Remove {
+		w.eventBuffer.Put(event.Name, fsnotify.Remove)
+	}
+}
+
+// registerPluginAt dials the plugin listening at socketPath, negotiates a
+// registration protocol version, invokes the handler for the plugin's
+// advertised type and reports the outcome back to the plugin. It is used
+// by the Reconciler as its registerFunc.
+func (w *Watcher) registerPluginAt(socketPath string) (pluginName, pluginType string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	conn, err := dial(ctx, socketPath)
+	if err != nil {
+		w.recordProbeFailure()
+		return "", "", fmt.Errorf("failed to dial %s: %v", socketPath, err)
+	}
+	defer conn.Close()
+
+	info, version, err := getPluginInfo(ctx, conn)
+	if err != nil {
+		w.recordProbeFailure()
+		return "", "", fmt.Errorf("failed to get plugin info for %s: %v", socketPath, err)
+	}
+
+	if trustErr := verifyTrust(w.getTrustPolicy(), info); trustErr != nil {
+		regErr := w.notifyFailure(ctx, conn, version, trustErr)
+		w.sendError(regErr)
+		return "", "", regErr
+	}
+
+	handler, ok := w.getHandler(info.Type)
+	if !ok {
+		regErr := fmt.Errorf("no handler registered for plugin type %s", info.Type)
+		return "", "", w.notifyFailure(ctx, conn, version, regErr)
+	}
+
+	if err := handler.RegisterPlugin(info.Name, info.Endpoint, version, socketPath); err != nil {
+		return "", "", w.notifyFailure(ctx, conn, version, err)
+	}
+
+	if err := notifyRegistrationStatus(ctx, conn, version, true, ""); err != nil {
+		return "", "", fmt.Errorf("failed to notify %s of successful registration: %v", socketPath, err)
+	}
+
+	return info.Name, info.Type, nil
+}
+
+// deregisterPlugin tells the handler registered for pluginType that
+// pluginName is gone. It is used by the Reconciler as its deregisterFunc.
+func (w *Watcher) deregisterPlugin(pluginType, pluginName string) {
+	handler, ok := w.getHandler(pluginType)
+	if !ok {
+		klog.Errorf("no handler registered for plugin type %s, cannot deregister %s", pluginType, pluginName)
+		return
+	}
+	handler.DeRegisterPlugin(pluginName)
+}
+
+func (w *Watcher) notifyFailure(ctx context.Context, conn *grpc.ClientConn, version string, regErr error) error {
+	if err := notifyRegistrationStatus(ctx, conn, version, false, regErr.Error()); err != nil {
+		klog.Errorf("failed to notify plugin of registration failure: %v", err)
+	}
+	return regErr
+}
+
+// getPluginInfo fetches the plugin's PluginInfo from whichever wire version
+// it answers GetInfo on, then negotiates the registration protocol version
+// to drive the rest of registration with: the highest-priority entry in
+// supportedVersions that the plugin also advertises via
+// PluginInfo.SupportedVersions. This is deliberately not just "whichever
+// wire version answered GetInfo" -- a plugin can implement a gRPC service
+// for a version it doesn't actually want to be driven on (e.g. a gateway
+// speaking multiple wire protocols), so the advertised set is authoritative.
+func getPluginInfo(ctx context.Context, conn *grpc.ClientConn) (*registerapi.PluginInfo, string, error) {
+	info, err := probePluginInfo(ctx, conn)
+	if err != nil {
+		return nil, "", err
+	}
+
+	version, err := negotiateVersion(info.SupportedVersions)
+	if err != nil {
+		return nil, "", fmt.Errorf("plugin %s: %v", info.Name, err)
+	}
+
+	return info, version, nil
+}
+
+// probePluginInfo calls GetInfo using each client stub we know about, newest
+// first, and returns the PluginInfo from the first one the plugin actually
+// implements. The returned PluginInfo is normalized to the v1 type
+// regardless of which wire version answered.
+func probePluginInfo(ctx context.Context, conn *grpc.ClientConn) (*registerapi.PluginInfo, error) {
+	for _, version := range supportedVersions {
+		switch version {
+		case registerapi.APIVersion:
+			client := registerapi.NewRegistrationClient(conn)
+			info, err := client.GetInfo(ctx, &registerapi.InfoRequest{})
+			if err == nil {
+				return info, nil
+			}
+			if status.Code(err) != codes.Unimplemented {
+				return nil, err
+			}
+		case registerapiv1alpha1.APIVersion:
+			client := registerapiv1alpha1.NewRegistrationClient(conn)
+			info, err := client.GetInfo(ctx, &registerapiv1alpha1.InfoRequest{})
+			if err == nil {
+				return &registerapi.PluginInfo{
+					Type:              info.Type,
+					Name:              info.Name,
+					Endpoint:          info.Endpoint,
+					SupportedVersions: info.SupportedVersions,
+					Digest:            info.Digest,
+					Signature:         info.Signature,
+					Signer:            info.Signer,
+				}, nil
+			}
+			if status.Code(err) != codes.Unimplemented {
+				return nil, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("plugin does not implement any registration version the kubelet supports (%v)", supportedVersions)
+}
+
+// negotiateVersion returns the highest-priority entry in supportedVersions
+// (the kubelet's own list, most preferred first) that also appears in
+// advertised (the plugin's PluginInfo.SupportedVersions), or an error if the
+// two sets have nothing in common.
+func negotiateVersion(advertised []string) (string, error) {
+	advertisedSet := sets.NewString(advertised...)
+	for _, version := range supportedVersions {
+		if advertisedSet.Has(version) {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("no common registration version between kubelet (%v) and plugin (%v)", supportedVersions, advertised)
+}
+
+// notifyRegistrationStatus calls NotifyRegistrationStatus using the client
+// stub for the negotiated version.
+func notifyRegistrationStatus(ctx context.Context, conn *grpc.ClientConn, version string, registered bool, errMsg string) error {
+	switch version {
+	case registerapiv1alpha1.APIVersion:
+		client := registerapiv1alpha1.NewRegistrationClient(conn)
+		_, err := client.NotifyRegistrationStatus(ctx, &registerapiv1alpha1.RegistrationStatus{
+			PluginRegistered: registered,
+			Error:            errMsg,
+		})
+		return err
+	default:
+		client := registerapi.NewRegistrationClient(conn)
+		_, err := client.NotifyRegistrationStatus(ctx, &registerapi.RegistrationStatus{
+			PluginRegistered: registered,
+			Error:            errMsg,
+		})
+		return err
+	}
+}
+
+func dial(ctx context.Context, unixSocketPath string) (*grpc.ClientConn, error) {
+	return grpc.DialContext(ctx, unixSocketPath,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}),
+	)
+}
+
+// sendError delivers err on w.errCh without blocking the caller: if the
+// channel's buffer is full (the consumer isn't keeping up, or isn't reading
+// at all), the error is dropped and logged rather than stalling whichever
+// goroutine -- the fsnotify read loop or the Reconciler -- tried to send it.
+func (w *Watcher) sendError(err error) {
+	select {
+	case w.errCh <- err:
+	default:
+		klog.Errorf("error channel full, dropping error: %v", err)
+	}
+}
+
+func (w *Watcher) socketExists(path string) (bool, error) {
+	info, err := w.fs.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.Mode()&os.ModeSocket != 0, nil
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package pluginwatcher
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -30,9 +32,77 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/kubernetes/pkg/kubelet/apis/pluginregistration/register"
 	registerapi "k8s.io/kubernetes/pkg/kubelet/apis/pluginregistration/v1alpha1"
 )
 
+// TestPluginRegistrationVersions exercises the version negotiation added to
+// the watcher: a plugin only advertising the legacy v1alpha1 registration
+// protocol and a plugin only advertising the newer v1 protocol must both be
+// able to register with the same watcher.
+func TestPluginRegistrationVersions(t *testing.T) {
+	rootDir, err := ioutil.TempDir("", "plugin_test")
+	require.NoError(t, err)
+	w := NewWatcher(rootDir)
+	h := NewExampleHandler()
+	w.AddHandler(registerapi.DevicePlugin, h)
+
+	ch, err := w.Start()
+	require.NoError(t, err)
+	stopCh := subscribeErrorChan(t, ch)
+	defer func() {
+		require.NoError(t, w.Stop())
+		close(stopCh)
+		require.NoError(t, w.Cleanup())
+	}()
+
+	legacy := NewTestExamplePlugin("legacy-plugin", registerapi.DevicePlugin, "legacy-endpoint", "v1alpha1")
+	require.NoError(t, legacy.Serve(filepath.Join(rootDir, "legacy.sock")))
+	defer legacy.Stop()
+	require.True(t, waitForPluginRegistrationStatus(t, legacy.registrationStatus))
+
+	current := NewTestExamplePlugin("current-plugin", registerapi.DevicePlugin, "current-endpoint", "v1")
+	require.NoError(t, current.Serve(filepath.Join(rootDir, "current.sock")))
+	defer current.Stop()
+	require.True(t, waitForPluginRegistrationStatus(t, current.registrationStatus))
+
+	both := NewTestExamplePlugin("both-plugin", registerapi.DevicePlugin, "both-endpoint", "v1alpha1", "v1")
+	require.NoError(t, both.Serve(filepath.Join(rootDir, "both.sock")))
+	defer both.Stop()
+	require.True(t, waitForPluginRegistrationStatus(t, both.registrationStatus))
+}
+
+// TestPluginVersionNegotiationRespectsAdvertisedVersions exercises a plugin
+// whose gRPC services and advertised PluginInfo.SupportedVersions disagree:
+// it serves both the v1 and v1alpha1 registration services, so the v1
+// GetInfo RPC succeeds, but it only declares v1alpha1 as supported.
+// Negotiation must pick v1alpha1, the highest version in common with what
+// the plugin actually advertises, not v1, which merely happened to answer
+// GetInfo.
+func TestPluginVersionNegotiationRespectsAdvertisedVersions(t *testing.T) {
+	rootDir, err := ioutil.TempDir("", "plugin_test")
+	require.NoError(t, err)
+	w := NewWatcher(rootDir)
+	h := NewExampleHandler()
+	w.AddHandler(registerapi.DevicePlugin, h)
+
+	ch, err := w.Start()
+	require.NoError(t, err)
+	stopCh := subscribeErrorChan(t, ch)
+	defer func() {
+		require.NoError(t, w.Stop())
+		close(stopCh)
+		require.NoError(t, w.Cleanup())
+	}()
+
+	p := NewTestExamplePlugin("mismatched-plugin", registerapi.DevicePlugin, "mismatched-endpoint", "v1", "v1alpha1").
+		WithDeclaredVersions("v1alpha1")
+	require.NoError(t, p.Serve(filepath.Join(rootDir, "mismatched.sock")))
+	defer p.Stop()
+	require.True(t, waitForPluginRegistrationStatus(t, p.registrationStatus))
+	require.Equal(t, "v1alpha1", h.registeredVersion("mismatched-plugin"))
+}
+
 // helper function
 func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
 	c := make(chan struct{})
@@ -53,7 +123,7 @@ func TestExamplePlugin(t *testing.T) {
 	require.NoError(t, err)
 	w := NewWatcher(rootDir)
 	h := NewExampleHandler()
-	w.AddHandler(registerapi.DevicePlugin, h.Handler)
+	w.AddHandler(registerapi.DevicePlugin, h)
 
 	ch, err := w.Start()
 	require.NoError(t, err)
@@ -76,11 +146,13 @@ func TestExamplePlugin(t *testing.T) {
 	// with "bind: address already in use"
 	require.NotNil(t, p.Serve(socketPath))
 
-	// grpcServer.Stop() will remove the socket and starting plugin service
-	// at the same path again should succeeds and trigger another callback.
+	// grpcServer.Stop() removes the socket; once the reconciler notices it's
+	// gone it deregisters the plugin, so serving a plugin with the same name
+	// at the same path again should succeed and trigger a fresh callback.
 	require.NoError(t, p.Stop())
+	require.True(t, waitForHandlerDeregistration(t, h, PluginName))
 	require.Nil(t, p.Serve(socketPath))
-	require.False(t, waitForPluginRegistrationStatus(t, p.registrationStatus))
+	require.True(t, waitForPluginRegistrationStatus(t, p.registrationStatus))
 
 	// Starting another plugin with the same name got verification error.
 	p2 := NewTestExamplePlugin(PluginName, registerapi.DevicePlugin, "dummyEndpoint")
@@ -121,12 +193,48 @@ func TestExamplePlugin(t *testing.T) {
 
 	require.Equal(t, expectedSet, actualSet)
 
-	select {
-	case err := <-h.chanForHandlerAckErrors:
-		t.Fatalf("%v", err)
-	case <-time.After(2 * time.Second):
-	}
+	time.Sleep(2 * time.Second)
+	require.Zero(t, w.ProbeFailureCount(), "watcher should not have attempted GetInfo against a socket before it was ready")
+
+	require.NoError(t, w.Stop())
+	close(stopCh)
+	require.NoError(t, w.Cleanup())
+}
+
+// TestPluginPublishSocket exercises the atomic publish path an external
+// plugin would use: probe that the watcher's root is up, then publish the
+// socket via register.PublishSocket instead of listening on a pre-chosen
+// path directly. The watcher should only ever see the socket once it's
+// already bound and accepting connections, so registration should succeed
+// on the very first attempt with no spurious "connection refused" GetInfo
+// attempts against it.
+func TestPluginPublishSocket(t *testing.T) {
+	rootDir, err := ioutil.TempDir("", "plugin_test")
+	require.NoError(t, err)
+
+	w := NewWatcher(rootDir)
+	h := NewExampleHandler()
+	w.AddHandler(registerapi.DevicePlugin, h)
+
+	ch, err := w.Start()
+	require.NoError(t, err)
+	stopCh := subscribeErrorChan(t, ch)
+
+	require.True(t, w.ProbeSocketPath())
+	require.True(t, register.ProbeWatcherRoot(rootDir))
+
+	dpDir := filepath.Join(rootDir, registerapi.DevicePlugin)
+	require.NoError(t, w.fs.MkdirAll(dpDir, 0755))
+
+	p := NewTestExamplePlugin("example-plugin", registerapi.DevicePlugin, "example-endpoint")
+	socketPath, err := p.PublishAndServe(dpDir)
+	require.NoError(t, err)
+	require.Equal(t, dpDir, filepath.Dir(socketPath))
+	require.True(t, waitForPluginRegistrationStatus(t, p.registrationStatus))
+
+	require.Zero(t, w.ProbeFailureCount(), "watcher should not have attempted GetInfo against the socket before PublishAndServe finished binding it")
 
+	require.NoError(t, p.Stop())
 	require.NoError(t, w.Stop())
 	close(stopCh)
 	require.NoError(t, w.Cleanup())
@@ -140,8 +248,8 @@ func TestPluginWithSubDir(t *testing.T) {
 	hcsi := NewExampleHandler()
 	hdp := NewExampleHandler()
 
-	w.AddHandler(registerapi.CSIPlugin, hcsi.Handler)
-	w.AddHandler(registerapi.DevicePlugin, hdp.Handler)
+	w.AddHandler(registerapi.CSIPlugin, hcsi)
+	w.AddHandler(registerapi.DevicePlugin, hdp)
 
 	err = w.fs.MkdirAll(filepath.Join(rootDir, registerapi.DevicePlugin), 0755)
 	require.NoError(t, err)
@@ -198,19 +306,20 @@ func TestPluginWithSubDir(t *testing.T) {
 
 	require.Equal(t, expectedSet, actualSet)
 
-	select {
-	case err := <-hcsi.chanForHandlerAckErrors:
-		t.Fatalf("%v", err)
-	case err := <-hdp.chanForHandlerAckErrors:
-		t.Fatalf("%v", err)
-	case <-time.After(4 * time.Second):
-	}
+	time.Sleep(4 * time.Second)
+	require.Zero(t, w.ProbeFailureCount(), "watcher should not have attempted GetInfo against a socket before it was ready")
 
 	require.NoError(t, w.Stop())
 	close(stopCh)
 	require.NoError(t, w.Cleanup())
 }
 
+// TestFloodedEvents floods the watcher with far more socket create/remove
+// events than fsnotify's own event channel can hold at once, to exercise
+// the coalescing event buffer: the fsnotify read loop must keep draining
+// without erroring, and because every socket in this test is removed right
+// after being created, the DesiredStateOfWorld should settle on "none of
+// them exist" and the handler should never see a lasting registration.
 func TestFloodedEvents(t *testing.T) {
 	rootDir, err := ioutil.TempDir("", "plugin_test")
 	require.NoError(t, err)
@@ -218,7 +327,7 @@ func TestFloodedEvents(t *testing.T) {
 	w := NewWatcher(rootDir)
 	hdp := NewExampleHandler()
 
-	w.AddHandler(registerapi.DevicePlugin, hdp.Handler)
+	w.AddHandler(registerapi.DevicePlugin, hdp)
 
 	err = w.fs.MkdirAll(filepath.Join(rootDir, registerapi.DevicePlugin), 0755)
 	require.NoError(t, err)
@@ -226,21 +335,21 @@ func TestFloodedEvents(t *testing.T) {
 	ch, err := w.Start()
 	require.NoError(t, err)
 
-	errReceived := make(chan interface{})
+	errReceived := make(chan error, 1)
 	stopWait := make(chan interface{})
 	go func() {
 		for {
 			select {
 			case err := <-ch:
 				if err != nil {
-					t.Logf("%v", err)
-					close(errReceived)
-					return
+					select {
+					case errReceived <- err:
+					default:
+					}
 				}
 			case <-stopWait:
 				return
 			}
-
 		}
 	}()
 
@@ -252,25 +361,40 @@ func TestFloodedEvents(t *testing.T) {
 		require.NoError(t, err)
 	}
 
+	var wg sync.WaitGroup
 	for dn := 0; dn < numDirs; dn++ {
 		subDir := fmt.Sprintf("%s/%s/%d", rootDir, registerapi.DevicePlugin, dn)
+		wg.Add(1)
 		go func() {
+			defer wg.Done()
 			for fn := 0; fn < numRepeat; fn++ {
 				socketPath := fmt.Sprintf("%s/%d", subDir, fn)
-				_, err := net.Listen("unix", socketPath)
+				l, err := net.Listen("unix", socketPath)
 				require.NoError(t, err)
+				l.Close()
 				w.fs.Remove(socketPath)
 			}
 		}()
 	}
 
-	select {
-	case <-errReceived:
-	case <-time.After(60 * time.Second):
+	if waitTimeout(&wg, 30*time.Second) {
 		close(stopWait)
-		t.Fatal("timeout while waiting for error happened")
+		t.Fatal("timed out generating flooded events")
 	}
 
+	// give the event buffer workers and the reconciler time to settle on
+	// the final state of every socket before asserting anything.
+	time.Sleep(2 * time.Second)
+	close(stopWait)
+
+	select {
+	case err := <-errReceived:
+		t.Fatalf("watcher reported an error under event flooding: %v", err)
+	default:
+	}
+
+	require.Zero(t, hdp.registeredCount(), "no socket should still look registered, they were all removed")
+
 	require.NoError(t, w.Stop())
 	require.NoError(t, w.Cleanup())
 }
@@ -285,6 +409,93 @@ func waitForPluginRegistrationStatus(t *testing.T, statusCh chan registerapi.Reg
 	return false
 }
 
+// waitForHandlerDeregistration polls until h has seen DeRegisterPlugin
+// called for pluginName, since the reconciler only notices a socket has
+// disappeared on its next periodic pass.
+func waitForHandlerDeregistration(t *testing.T, h *exampleHandler, pluginName string) bool {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if h.isDeregistered(pluginName) {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
+
+// TestTrustPolicy exercises both the accept and reject paths of
+// Watcher.SetTrustPolicy: a plugin signed by a trusted, required signer
+// registers as normal, while a plugin signed by an untrusted key and a
+// plugin that isn't signed at all are both rejected before the handler
+// ever sees them, with the rejection surfaced on the watcher's error
+// channel as an *ErrUntrustedPlugin.
+func TestTrustPolicy(t *testing.T) {
+	rootDir, err := ioutil.TempDir("", "plugin_test")
+	require.NoError(t, err)
+
+	trustedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	w := NewWatcher(rootDir)
+	h := NewExampleHandler()
+	w.AddHandler(registerapi.DevicePlugin, h)
+	w.SetTrustPolicy(TrustPolicy{
+		TrustedKeys:     map[string]*rsa.PublicKey{"team-a": &trustedKey.PublicKey},
+		RequiredSigners: sets.NewString("team-a"),
+	})
+
+	ch, err := w.Start()
+	require.NoError(t, err)
+
+	untrustedErrors := make(chan error, 10)
+	stopCh := make(chan interface{})
+	go func() {
+		for {
+			select {
+			case err := <-ch:
+				if err != nil {
+					untrustedErrors <- err
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	trusted := NewTestExamplePlugin("trusted-plugin", registerapi.DevicePlugin, "trusted-endpoint").
+		WithSignedInfo("team-a", trustedKey)
+	require.NoError(t, trusted.Serve(filepath.Join(rootDir, "trusted.sock")))
+	require.True(t, waitForPluginRegistrationStatus(t, trusted.registrationStatus))
+	require.True(t, h.isRegistered("trusted-plugin"))
+	require.NoError(t, trusted.Stop())
+
+	untrusted := NewTestExamplePlugin("untrusted-plugin", registerapi.DevicePlugin, "untrusted-endpoint").
+		WithSignedInfo("team-a", otherKey)
+	require.NoError(t, untrusted.Serve(filepath.Join(rootDir, "untrusted.sock")))
+	require.False(t, waitForPluginRegistrationStatus(t, untrusted.registrationStatus))
+	require.False(t, h.isRegistered("untrusted-plugin"))
+	require.NoError(t, untrusted.Stop())
+
+	select {
+	case err := <-untrustedErrors:
+		require.IsType(t, &ErrUntrustedPlugin{}, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an *ErrUntrustedPlugin on the watcher's error channel")
+	}
+
+	unsigned := NewTestExamplePlugin("unsigned-plugin", registerapi.DevicePlugin, "unsigned-endpoint")
+	require.NoError(t, unsigned.Serve(filepath.Join(rootDir, "unsigned.sock")))
+	require.False(t, waitForPluginRegistrationStatus(t, unsigned.registrationStatus))
+	require.False(t, h.isRegistered("unsigned-plugin"))
+	require.NoError(t, unsigned.Stop())
+
+	close(stopCh)
+	require.NoError(t, w.Stop())
+	require.NoError(t, w.Cleanup())
+}
+
 func subscribeErrorChan(t *testing.T, ch <-chan error) chan interface{} {
 	stopCh := make(chan interface{})
 	go func() {
@@ -0,0 +1,139 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pluginwatcher
+
+import (
+	"time"
+
+	"k8s.io/klog"
+)
+
+// reconcilerLoopSleepDuration is how often the reconciler diffs desired and
+// actual state.
+const reconcilerLoopSleepDuration = 1 * time.Second
+
+// registerFunc attempts to register the plugin listening at socketPath and
+// returns the name and type it registered as.
+type registerFunc func(socketPath string) (pluginName, pluginType string, err error)
+
+// deregisterFunc tells the handler for pluginType that pluginName is gone.
+type deregisterFunc func(pluginType, pluginName string)
+
+// Reconciler periodically reconciles a DesiredStateOfWorld (plugin sockets
+// found on disk) against an ActualStateOfWorld (plugin sockets whose
+// handlers have acked registration): new sockets get registered, and
+// sockets that have disappeared get deregistered. It only acts on the
+// difference between the two states, so a socket whose generation hasn't
+// changed is not registered twice. Repopulating the desired state from
+// scratch gives every socket a new generation, so each one already
+// registered is deregistered and registered again.
+type Reconciler struct {
+	dsw        DesiredStateOfWorld
+	asw        ActualStateOfWorld
+	register   registerFunc
+	deregister deregisterFunc
+
+	// rejected tracks, per socket path, the generation that last failed
+	// registration with a permanent error (currently just
+	// ErrUntrustedPlugin) so it isn't retried every reconcile pass. It's
+	// only ever read and written from reconcile(), which never runs
+	// concurrently with itself, so it needs no locking of its own.
+	rejected map[string]int64
+}
+
+// NewReconciler returns a Reconciler that reconciles dsw against asw,
+// calling register for newly desired sockets and deregister for registered
+// sockets that are no longer desired.
+func NewReconciler(dsw DesiredStateOfWorld, asw ActualStateOfWorld, register registerFunc, deregister deregisterFunc) *Reconciler {
+	return &Reconciler{
+		dsw:        dsw,
+		asw:        asw,
+		register:   register,
+		deregister: deregister,
+		rejected:   make(map[string]int64),
+	}
+}
+
+// Run reconciles once every reconcilerLoopSleepDuration until stopCh is
+// closed.
+func (r *Reconciler) Run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-time.After(reconcilerLoopSleepDuration):
+			r.reconcile()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (r *Reconciler) reconcile() {
+	desired := r.dsw.GetPluginsToRegister()
+	actual := r.asw.GetRegisteredPlugins()
+
+	for socketPath, generation := range desired {
+		if rejectedGeneration, ok := r.rejected[socketPath]; ok && rejectedGeneration == generation {
+			continue
+		}
+		plugin, ok := actual[socketPath]
+		if ok && plugin.generation == generation {
+			continue
+		}
+		if ok {
+			// The socket at this path was already registered, but under an
+			// earlier generation: it's since been replaced by a new socket
+			// instance (e.g. a fast plugin restart whose Remove and Create
+			// coalesced into a single buffered event), so the old
+			// registration is stale and must be torn down before the new
+			// one is registered.
+			klog.V(2).Infof("plugin %s (%s) at %s was replaced, deregistering stale registration", plugin.pluginName, plugin.pluginType, socketPath)
+			r.deregister(plugin.pluginType, plugin.pluginName)
+			r.asw.RemovePlugin(socketPath)
+		}
+		pluginName, pluginType, err := r.register(socketPath)
+		if err != nil {
+			if _, untrusted := err.(*ErrUntrustedPlugin); untrusted {
+				// Unlike a dial or GetInfo failure, which may just mean the
+				// plugin hasn't finished starting up yet, a trust rejection
+				// is deterministic for this generation of the socket, so
+				// it isn't retried until the plugin is replaced (a new
+				// generation). A later SetTrustPolicy call does not by
+				// itself cause a retry.
+				r.rejected[socketPath] = generation
+			}
+			klog.Errorf("failed to register plugin at %s: %v", socketPath, err)
+			continue
+		}
+		delete(r.rejected, socketPath)
+		r.asw.AddPlugin(socketPath, pluginName, pluginType, generation)
+	}
+
+	for socketPath := range r.rejected {
+		if _, ok := desired[socketPath]; !ok {
+			delete(r.rejected, socketPath)
+		}
+	}
+
+	for socketPath, plugin := range actual {
+		if _, ok := desired[socketPath]; ok {
+			continue
+		}
+		klog.V(2).Infof("plugin %s (%s) at %s disappeared, deregistering", plugin.pluginName, plugin.pluginType, socketPath)
+		r.deregister(plugin.pluginType, plugin.pluginName)
+		r.asw.RemovePlugin(socketPath)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pluginwatcher
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestReconcileReplacesStaleGeneration exercises the case a coalesced
+// Remove-then-Create collapses into a single buffered Create for: even
+// though the socket path never left the DesiredStateOfWorld, a fresh call
+// to AddOrUpdatePlugin bumps its generation, and reconcile must deregister
+// the stale registration before registering the new one rather than
+// treating the path as already handled.
+func TestReconcileReplacesStaleGeneration(t *testing.T) {
+	dsw := NewDesiredStateOfWorld()
+	asw := NewActualStateOfWorld()
+
+	const socketPath = "/var/lib/kubelet/plugins/some.sock"
+	dsw.AddOrUpdatePlugin(socketPath)
+
+	var registerCalls, deregisterCalls int
+	register := func(p string) (string, string, error) {
+		registerCalls++
+		return "plugin", "DevicePlugin", nil
+	}
+	deregister := func(pluginType, pluginName string) {
+		deregisterCalls++
+	}
+
+	r := NewReconciler(dsw, asw, register, deregister)
+	r.reconcile()
+	require.Equal(t, 1, registerCalls)
+	require.Equal(t, 0, deregisterCalls)
+
+	// Simulate the plugin restarting fast enough that its Remove and
+	// re-Create coalesced into one buffered event: the path never left
+	// dsw from the Reconciler's point of view, but it's a new generation.
+	dsw.AddOrUpdatePlugin(socketPath)
+	r.reconcile()
+	require.Equal(t, 2, registerCalls, "the replaced socket should be re-registered")
+	require.Equal(t, 1, deregisterCalls, "the stale registration should be torn down first")
+
+	// A further reconcile with nothing changed should be a no-op.
+	r.reconcile()
+	require.Equal(t, 2, registerCalls)
+	require.Equal(t, 1, deregisterCalls)
+}
+
+// TestReconcileSkipsRegistrationFailure exercises that a register failure
+// leaves the socket out of the ActualStateOfWorld, so the next reconcile
+// retries it.
+func TestReconcileSkipsRegistrationFailure(t *testing.T) {
+	dsw := NewDesiredStateOfWorld()
+	asw := NewActualStateOfWorld()
+
+	const socketPath = "/var/lib/kubelet/plugins/broken.sock"
+	dsw.AddOrUpdatePlugin(socketPath)
+
+	attempts := 0
+	register := func(p string) (string, string, error) {
+		attempts++
+		if attempts == 1 {
+			return "", "", fmt.Errorf("dial failed")
+		}
+		return "plugin", "DevicePlugin", nil
+	}
+	deregister := func(pluginType, pluginName string) {}
+
+	r := NewReconciler(dsw, asw, register, deregister)
+	r.reconcile()
+	require.Empty(t, asw.GetRegisteredPlugins())
+
+	r.reconcile()
+	require.Len(t, asw.GetRegisteredPlugins(), 1)
+}
+
+// TestReconcileStopsRetryingRejectedPlugin exercises that a trust rejection,
+// unlike an ordinary registration failure, is not retried every reconcile
+// pass -- until the socket is replaced by a new generation, at which point
+// it gets a fresh attempt.
+func TestReconcileStopsRetryingRejectedPlugin(t *testing.T) {
+	dsw := NewDesiredStateOfWorld()
+	asw := NewActualStateOfWorld()
+
+	const socketPath = "/var/lib/kubelet/plugins/untrusted.sock"
+	dsw.AddOrUpdatePlugin(socketPath)
+
+	attempts := 0
+	register := func(p string) (string, string, error) {
+		attempts++
+		return "", "", &ErrUntrustedPlugin{PluginName: "untrusted", Reason: "not signed"}
+	}
+	deregister := func(pluginType, pluginName string) {}
+
+	r := NewReconciler(dsw, asw, register, deregister)
+	r.reconcile()
+	require.Equal(t, 1, attempts)
+
+	r.reconcile()
+	r.reconcile()
+	require.Equal(t, 1, attempts, "a rejected socket at the same generation should not be retried")
+
+	// The plugin restarts with a new socket instance at the same path.
+	dsw.AddOrUpdatePlugin(socketPath)
+	r.reconcile()
+	require.Equal(t, 2, attempts, "a new generation should get a fresh registration attempt")
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pluginwatcher
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	registerapi "k8s.io/kubernetes/pkg/kubelet/apis/pluginregistration/v1"
+)
+
+// TrustPolicy governs whether Watcher requires a valid signature over a
+// plugin's PluginInfo before invoking any handler for it. Until
+// Watcher.SetTrustPolicy is called, every plugin is trusted regardless of
+// whether it carries a digest and signature.
+type TrustPolicy struct {
+	// TrustedKeys maps a signer name (as carried in PluginInfo.Signer) to
+	// the public key used to verify signatures it produces.
+	TrustedKeys map[string]*rsa.PublicKey
+	// RequiredSigners, if non-empty, restricts which of TrustedKeys' names
+	// are acceptable signers; a plugin signed by a key not in this set is
+	// rejected even though the key itself is trusted.
+	RequiredSigners sets.String
+}
+
+// ErrUntrustedPlugin is returned by Watcher, and surfaced on its error
+// channel, when a TrustPolicy is set and a registering plugin's
+// PluginInfo fails verification against it.
+type ErrUntrustedPlugin struct {
+	PluginName string
+	Reason     string
+}
+
+func (e *ErrUntrustedPlugin) Error() string {
+	return fmt.Sprintf("plugin %q is not trusted: %s", e.PluginName, e.Reason)
+}
+
+// canonicalManifest deterministically serializes the parts of info a
+// signature covers, independent of field or slice ordering elsewhere in
+// the gRPC message, so the signer and verifier always hash the same bytes.
+func canonicalManifest(info *registerapi.PluginInfo) []byte {
+	versions := append([]string(nil), info.SupportedVersions...)
+	sort.Strings(versions)
+	return []byte(fmt.Sprintf("name=%s\ntype=%s\nendpoint=%s\nversions=%s\n",
+		info.Name, info.Type, info.Endpoint, strings.Join(versions, ",")))
+}
+
+// verifyTrust checks info against policy. A nil policy trusts everything.
+func verifyTrust(policy *TrustPolicy, info *registerapi.PluginInfo) error {
+	if policy == nil {
+		return nil
+	}
+
+	if policy.RequiredSigners.Len() > 0 && !policy.RequiredSigners.Has(info.Signer) {
+		return &ErrUntrustedPlugin{
+			PluginName: info.Name,
+			Reason:     fmt.Sprintf("signer %q is not in the required signers set", info.Signer),
+		}
+	}
+
+	key, ok := policy.TrustedKeys[info.Signer]
+	if !ok {
+		return &ErrUntrustedPlugin{
+			PluginName: info.Name,
+			Reason:     fmt.Sprintf("signer %q is not a trusted key", info.Signer),
+		}
+	}
+
+	digest := sha256.Sum256(canonicalManifest(info))
+	if hex.EncodeToString(digest[:]) != info.Digest {
+		return &ErrUntrustedPlugin{PluginName: info.Name, Reason: "digest does not match the advertised manifest"}
+	}
+
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], info.Signature); err != nil {
+		return &ErrUntrustedPlugin{PluginName: info.Name, Reason: fmt.Sprintf("signature verification failed: %v", err)}
+	}
+
+	return nil
+}
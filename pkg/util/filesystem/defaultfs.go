@@ -0,0 +1,52 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// DefaultFs is a Filesystem backed by the real operating system.
+type DefaultFs struct{}
+
+var _ Filesystem = &DefaultFs{}
+
+// Stat via os.Stat
+func (fs *DefaultFs) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// MkdirAll via os.MkdirAll
+func (fs *DefaultFs) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// ReadDir via ioutil.ReadDir
+func (fs *DefaultFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(dirname)
+}
+
+// Remove via os.Remove
+func (fs *DefaultFs) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// RemoveAll via os.RemoveAll
+func (fs *DefaultFs) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}